@@ -50,8 +50,15 @@ func SetupAppServiceAPIComponent(
 		logrus.WithError(err).Panicf("failed to connect to appservice db")
 	}
 
-	workerStates := make([]types.ApplicationServiceWorkerState, len(base.Cfg.Derived.ApplicationServices))
+	workerStates := make([]types.ApplicationServiceWorkerState, 0, len(base.Cfg.Derived.ApplicationServices))
 	for _, appservice := range base.Cfg.Derived.ApplicationServices {
+		// Precompile the namespace regexes once up front so that the
+		// consumer's hot path never has to recompile a pattern per event.
+		if err := appservice.CompileNamespaces(); err != nil {
+			logrus.WithError(err).Panicf("failed to compile namespaces for appservice %s",
+				appservice.ID)
+		}
+
 		// Wrap each application service in a type that relates the application
 		// service and a sync.Cond object that can be used to notify workers when
 		// there are new events to be sent out.
@@ -62,6 +69,7 @@ func SetupAppServiceAPIComponent(
 			AppService:  appservice,
 			Cond:        sync.NewCond(&m),
 			EventsReady: &eventCount,
+			Health:      &types.HealthState{},
 		}
 		workerStates = append(workerStates, ws)
 
@@ -83,6 +91,20 @@ func SetupAppServiceAPIComponent(
 		logrus.WithError(err).Panicf("failed to start app service roomserver consumer")
 	}
 
+	// Consume typing, receipt and presence events too, so that application
+	// services that opted into de.sorunome.msc2409.push_ephemeral receive
+	// them batched into the same outbound transactions as their PDUs.
+	ephemeralConsumers := []*consumers.EphemeralEventConsumer{
+		consumers.NewOutputTypingEventConsumer(base.Cfg, base.KafkaConsumer, appserviceDB, workerStates),
+		consumers.NewOutputReceiptEventConsumer(base.Cfg, base.KafkaConsumer, appserviceDB, workerStates),
+		consumers.NewOutputPresenceEventConsumer(base.Cfg, base.KafkaConsumer, appserviceDB, workerStates),
+	}
+	for _, ec := range ephemeralConsumers {
+		if err := ec.Start(); err != nil {
+			logrus.WithError(err).Panicf("failed to start app service ephemeral event consumer")
+		}
+	}
+
 	// Create application service transaction workers
 	if err := workers.SetupTransactionWorkers(appserviceDB, workerStates); err != nil {
 		logrus.WithError(err).Panicf("failed to start app service transaction workers")
@@ -91,7 +113,7 @@ func SetupAppServiceAPIComponent(
 	// Set up HTTP Endpoints
 	routing.Setup(
 		base.APIMux, *base.Cfg, roomserverQueryAPI, roomserverAliasAPI,
-		accountsDB, federation, transactionsCache,
+		accountsDB, federation, transactionsCache, workerStates,
 	)
 }
 
@@ -0,0 +1,41 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth contains helpers for authenticating requests that claim to
+// come from a registered application service. The client API's
+// m.login.application_service handler uses it.
+package auth
+
+import (
+	"errors"
+
+	"github.com/matrix-org/dendrite/common/config"
+)
+
+// ErrAppServiceTokenNotFound is returned by ValidateAccessToken when no
+// configured application service owns the given token.
+var ErrAppServiceTokenNotFound = errors.New("appservice: no application service found for supplied access token")
+
+// ValidateAccessToken searches asList for the application service
+// registered with the given AS token, as used to authenticate requests that
+// an application service makes to the homeserver (e.g.
+// m.login.application_service).
+func ValidateAccessToken(accessToken string, asList []config.ApplicationService) (*config.ApplicationService, error) {
+	for i := range asList {
+		if asList[i].ASToken == accessToken {
+			return &asList[i], nil
+		}
+	}
+	return nil, ErrAppServiceTokenNotFound
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/appservice/types"
+	"github.com/matrix-org/dendrite/common"
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ephemeralOutputEvent is implemented by each topic's output message shape,
+// since typing, receipt and presence events disagree on which of room_id and
+// user_id they carry. It knows how to decide whether an application
+// service's namespaces make it interested in the event, and how to render
+// the event as an MSC2409 `de.sorunome.msc2409.ephemeral` entry.
+type ephemeralOutputEvent interface {
+	interestsAppService(as config.ApplicationService) bool
+	ephemeralEvent(msc2409Type string) (json.RawMessage, error)
+}
+
+// typingOutputEvent is the shape of a message on the typing output log: one
+// entry per room, naming every user currently typing in it. There is no
+// single user_id, since typing is reported per-room rather than per-user.
+type typingOutputEvent struct {
+	RoomID  string   `json:"room_id"`
+	UserIDs []string `json:"user_ids"`
+}
+
+func (ev typingOutputEvent) interestsAppService(as config.ApplicationService) bool {
+	if as.IsInterestedInRoomID(ev.RoomID) {
+		return true
+	}
+	for _, userID := range ev.UserIDs {
+		if as.IsInterestedInUserID(userID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ev typingOutputEvent) ephemeralEvent(msc2409Type string) (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Type    string `json:"type"`
+		RoomID  string `json:"room_id"`
+		Content struct {
+			UserIDs []string `json:"user_ids"`
+		} `json:"content"`
+	}{
+		Type:   msc2409Type,
+		RoomID: ev.RoomID,
+		Content: struct {
+			UserIDs []string `json:"user_ids"`
+		}{UserIDs: ev.UserIDs},
+	})
+}
+
+// receiptOutputEvent is the shape of a message on the receipt output log:
+// one entry per room, carrying the same event-id -> receipt-type -> user-id
+// structure as the client-facing m.receipt content. Like typing, there is
+// no single user_id at the top level.
+type receiptOutputEvent struct {
+	RoomID  string                                           `json:"room_id"`
+	Content map[string]map[string]map[string]json.RawMessage `json:"content"`
+}
+
+func (ev receiptOutputEvent) interestsAppService(as config.ApplicationService) bool {
+	if as.IsInterestedInRoomID(ev.RoomID) {
+		return true
+	}
+	for _, receiptTypes := range ev.Content {
+		for _, users := range receiptTypes {
+			for userID := range users {
+				if as.IsInterestedInUserID(userID) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (ev receiptOutputEvent) ephemeralEvent(msc2409Type string) (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Type    string                                           `json:"type"`
+		RoomID  string                                           `json:"room_id"`
+		Content map[string]map[string]map[string]json.RawMessage `json:"content"`
+	}{Type: msc2409Type, RoomID: ev.RoomID, Content: ev.Content})
+}
+
+// presenceOutputEvent is the shape of a message on the presence output log:
+// one entry per user, with no associated room at all.
+type presenceOutputEvent struct {
+	UserID  string          `json:"user_id"`
+	Content json.RawMessage `json:"content"`
+}
+
+func (ev presenceOutputEvent) interestsAppService(as config.ApplicationService) bool {
+	return as.IsInterestedInUserID(ev.UserID)
+}
+
+func (ev presenceOutputEvent) ephemeralEvent(msc2409Type string) (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Type    string          `json:"type"`
+		Sender  string          `json:"sender"`
+		Content json.RawMessage `json:"content"`
+	}{Type: msc2409Type, Sender: ev.UserID, Content: ev.Content})
+}
+
+// decodeEphemeralOutputEvent unmarshals an output log message into the
+// shape appropriate for its topic, keyed by the same MSC2409 event type
+// each consumer was constructed with.
+func decodeEphemeralOutputEvent(msc2409Type string, data []byte) (ephemeralOutputEvent, error) {
+	switch msc2409Type {
+	case "m.typing":
+		var ev typingOutputEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case "m.receipt":
+		var ev receiptOutputEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	case "m.presence":
+		var ev presenceOutputEvent
+		err := json.Unmarshal(data, &ev)
+		return ev, err
+	default:
+		return nil, fmt.Errorf("appservice consumer: unknown ephemeral event type %q", msc2409Type)
+	}
+}
+
+// EphemeralEventConsumer consumes one of the typing, receipt or presence
+// output log topics and queues an MSC2409 `de.sorunome.msc2409.ephemeral`
+// entry for every application service whose namespaces match it and which
+// has opted in with `de.sorunome.msc2409.push_ephemeral`. It shares the same
+// workerStates and transaction queue as OutputRoomEventConsumer, so an
+// application service receives its PDUs and ephemeral events batched into
+// the same outbound transaction.
+type EphemeralEventConsumer struct {
+	consumer     *common.ContinualConsumer
+	appserviceDB *storage.Database
+	workerStates []types.ApplicationServiceWorkerState
+	msc2409Type  string
+}
+
+func newEphemeralEventConsumer(
+	topic, msc2409Type string,
+	kafkaConsumer sarama.Consumer,
+	appserviceDB *storage.Database,
+	workerStates []types.ApplicationServiceWorkerState,
+) *EphemeralEventConsumer {
+	c := &EphemeralEventConsumer{
+		appserviceDB: appserviceDB,
+		workerStates: workerStates,
+		msc2409Type:  msc2409Type,
+	}
+	c.consumer = &common.ContinualConsumer{
+		Topic:          topic,
+		Consumer:       kafkaConsumer,
+		PartitionStore: nil,
+		ProcessMessage: c.onMessage,
+	}
+	return c
+}
+
+// NewOutputTypingEventConsumer creates a consumer of the typing output log.
+func NewOutputTypingEventConsumer(
+	cfg *config.Dendrite, kafkaConsumer sarama.Consumer,
+	appserviceDB *storage.Database, workerStates []types.ApplicationServiceWorkerState,
+) *EphemeralEventConsumer {
+	return newEphemeralEventConsumer(
+		cfg.Kafka.Topics.OutputTypingEvent, "m.typing", kafkaConsumer, appserviceDB, workerStates,
+	)
+}
+
+// NewOutputReceiptEventConsumer creates a consumer of the receipt output log.
+func NewOutputReceiptEventConsumer(
+	cfg *config.Dendrite, kafkaConsumer sarama.Consumer,
+	appserviceDB *storage.Database, workerStates []types.ApplicationServiceWorkerState,
+) *EphemeralEventConsumer {
+	return newEphemeralEventConsumer(
+		cfg.Kafka.Topics.OutputReceiptEvent, "m.receipt", kafkaConsumer, appserviceDB, workerStates,
+	)
+}
+
+// NewOutputPresenceEventConsumer creates a consumer of the presence output
+// log.
+func NewOutputPresenceEventConsumer(
+	cfg *config.Dendrite, kafkaConsumer sarama.Consumer,
+	appserviceDB *storage.Database, workerStates []types.ApplicationServiceWorkerState,
+) *EphemeralEventConsumer {
+	return newEphemeralEventConsumer(
+		cfg.Kafka.Topics.OutputPresenceEvent, "m.presence", kafkaConsumer, appserviceDB, workerStates,
+	)
+}
+
+// Start starts consuming from this consumer's output log topic.
+func (c *EphemeralEventConsumer) Start() error {
+	return c.consumer.Start()
+}
+
+func (c *EphemeralEventConsumer) onMessage(msg *sarama.ConsumerMessage) error {
+	ev, err := decodeEphemeralOutputEvent(c.msc2409Type, msg.Value)
+	if err != nil {
+		logrus.WithError(err).Error("appservice consumer: failed to parse ephemeral event")
+		return nil
+	}
+
+	msc2409Event, err := ev.ephemeralEvent(c.msc2409Type)
+	if err != nil {
+		return err
+	}
+
+	for i := range c.workerStates {
+		ws := &c.workerStates[i]
+		if !ws.AppService.PushEphemeral {
+			continue
+		}
+		if !ev.interestsAppService(ws.AppService) {
+			continue
+		}
+
+		if err := c.appserviceDB.StoreEphemeralEvent(
+			context.Background(), ws.AppService.ID, msc2409Event, time.Now().UnixNano()/int64(time.Millisecond),
+		); err != nil {
+			logrus.WithError(err).WithField("appservice", ws.AppService.ID).
+				Error("failed to queue ephemeral event for appservice")
+			continue
+		}
+		ws.NotifyNewEvents()
+	}
+
+	return nil
+}
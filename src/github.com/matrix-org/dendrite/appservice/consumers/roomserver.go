@@ -0,0 +1,155 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consumers contains the kafka consumers that feed the appservice
+// component's outbound transaction queue.
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/appservice/types"
+	"github.com/matrix-org/dendrite/clientapi/auth/storage/accounts"
+	"github.com/matrix-org/dendrite/common"
+	"github.com/matrix-org/dendrite/common/config"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+// OutputRoomEventConsumer consumes new room events output by the roomserver,
+// works out which (if any) of the configured application services are
+// interested in them, and queues a transaction for each one.
+type OutputRoomEventConsumer struct {
+	cfg          *config.Dendrite
+	consumer     *common.ContinualConsumer
+	accountsDB   *accounts.Database
+	appserviceDB *storage.Database
+	queryAPI     roomserverAPI.RoomserverQueryAPI
+	aliasAPI     roomserverAPI.RoomserverAliasAPI
+	workerStates []types.ApplicationServiceWorkerState
+}
+
+// NewOutputRoomEventConsumer creates a new consumer for the roomserver's
+// output room event log, ready to be started with Start().
+func NewOutputRoomEventConsumer(
+	cfg *config.Dendrite,
+	kafkaConsumer sarama.Consumer,
+	accountsDB *accounts.Database,
+	appserviceDB *storage.Database,
+	queryAPI roomserverAPI.RoomserverQueryAPI,
+	aliasAPI roomserverAPI.RoomserverAliasAPI,
+	workerStates []types.ApplicationServiceWorkerState,
+) *OutputRoomEventConsumer {
+	c := &OutputRoomEventConsumer{
+		cfg:          cfg,
+		accountsDB:   accountsDB,
+		appserviceDB: appserviceDB,
+		queryAPI:     queryAPI,
+		aliasAPI:     aliasAPI,
+		workerStates: workerStates,
+	}
+	c.consumer = &common.ContinualConsumer{
+		Topic:          cfg.Kafka.Topics.OutputRoomEvent,
+		Consumer:       kafkaConsumer,
+		PartitionStore: nil,
+		ProcessMessage: c.onMessage,
+	}
+	return c
+}
+
+// Start starts consuming from the roomserver output room event topic.
+func (s *OutputRoomEventConsumer) Start() error {
+	return s.consumer.Start()
+}
+
+// onMessage is called for every event the roomserver outputs. It only fans
+// the event out to the application services whose namespaces actually match
+// it, rather than notifying every worker on every event.
+func (s *OutputRoomEventConsumer) onMessage(msg *sarama.ConsumerMessage) error {
+	var output roomserverAPI.OutputEvent
+	if err := json.Unmarshal(msg.Value, &output); err != nil {
+		logrus.WithError(err).Error("appservice consumer: failed to parse output room event")
+		return nil
+	}
+	if output.Type != roomserverAPI.OutputTypeNewRoomEvent {
+		return nil
+	}
+	event := output.NewRoomEvent.Event
+
+	eventJSON, err := json.Marshal([]*gomatrixserverlib.HeaderedEvent{event})
+	if err != nil {
+		return err
+	}
+
+	for i := range s.workerStates {
+		ws := &s.workerStates[i]
+		if !s.appServiceInterestedInEvent(context.Background(), ws.AppService, event) {
+			continue
+		}
+
+		if _, err := s.appserviceDB.StoreTransaction(
+			context.Background(), ws.AppService.ID, eventJSON, time.Now().UnixNano()/int64(time.Millisecond),
+		); err != nil {
+			logrus.WithError(err).WithField("appservice", ws.AppService.ID).
+				Error("failed to queue event for appservice")
+			continue
+		}
+		ws.NotifyNewEvents()
+	}
+
+	return nil
+}
+
+// appServiceInterestedInEvent reports whether as has registered a namespace
+// that matches this event, either because the sender or room is in its
+// namespaces, or — for membership events, notably invites — because the
+// *target* of the membership change is a user it owns, or because one of
+// the room's published aliases falls in its "aliases" namespace.
+func (s *OutputRoomEventConsumer) appServiceInterestedInEvent(
+	ctx context.Context, as config.ApplicationService, event *gomatrixserverlib.HeaderedEvent,
+) bool {
+	if as.IsInterestedInUserID(event.Sender()) {
+		return true
+	}
+	if as.IsInterestedInRoomID(event.RoomID()) {
+		return true
+	}
+	if event.Type() == "m.room.member" && event.StateKey() != nil {
+		if as.IsInterestedInUserID(*event.StateKey()) {
+			return true
+		}
+	}
+	if len(as.AliasNamespaces()) > 0 {
+		var res roomserverAPI.GetAliasesForRoomIDResponse
+		err := s.aliasAPI.GetAliasesForRoomID(
+			ctx, &roomserverAPI.GetAliasesForRoomIDRequest{RoomID: event.RoomID()}, &res,
+		)
+		if err != nil {
+			logrus.WithError(err).WithField("room_id", event.RoomID()).
+				Warn("appservice consumer: failed to resolve room aliases for namespace match")
+			return false
+		}
+		for _, alias := range res.Aliases {
+			if as.IsInterestedInRoomAlias(alias) {
+				return true
+			}
+		}
+	}
+	return false
+}
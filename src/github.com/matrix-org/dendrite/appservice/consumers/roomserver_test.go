@@ -0,0 +1,96 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func mustBuildEvent(t *testing.T, sender, roomID, eventType string, stateKey *string) *gomatrixserverlib.HeaderedEvent {
+	t.Helper()
+	builder := gomatrixserverlib.EventBuilder{
+		Sender:   sender,
+		RoomID:   roomID,
+		Type:     eventType,
+		StateKey: stateKey,
+		Content:  []byte(`{}`),
+	}
+	event, err := builder.Build(
+		gomatrixserverlib.EventReference{}, nil, "", gomatrixserverlib.RoomVersionV4,
+	)
+	if err != nil {
+		t.Fatalf("failed to build test event: %s", err)
+	}
+	headered := event.Headered(gomatrixserverlib.RoomVersionV4)
+	return &headered
+}
+
+func appServiceWithNamespaces(t *testing.T, userRegex string, exclusive bool) config.ApplicationService {
+	t.Helper()
+	as := config.ApplicationService{
+		ID: "test_as",
+		NamespaceMap: map[string][]config.ApplicationServiceNamespace{
+			"users": {
+				{Exclusive: exclusive, Regex: userRegex},
+			},
+		},
+	}
+	if err := as.CompileNamespaces(); err != nil {
+		t.Fatalf("failed to compile namespaces: %s", err)
+	}
+	return as
+}
+
+func TestAppServiceInterestedInEvent_MatchesOwnSender(t *testing.T) {
+	as := appServiceWithNamespaces(t, `@_bridge_.*:test`, true)
+	event := mustBuildEvent(t, "@_bridge_bob:test", "!room:test", "m.room.message", nil)
+
+	if !(&OutputRoomEventConsumer{}).appServiceInterestedInEvent(context.Background(), as, event) {
+		t.Fatalf("expected appservice to be interested in its own namespaced sender")
+	}
+}
+
+func TestAppServiceInterestedInEvent_ExclusiveNamespaceExcludesOthers(t *testing.T) {
+	as := appServiceWithNamespaces(t, `@_bridge_.*:test`, true)
+	event := mustBuildEvent(t, "@alice:test", "!room:test", "m.room.message", nil)
+
+	if (&OutputRoomEventConsumer{}).appServiceInterestedInEvent(context.Background(), as, event) {
+		t.Fatalf("did not expect appservice to be interested in a non-namespaced sender")
+	}
+}
+
+func TestAppServiceInterestedInEvent_InviteToBridgedUser(t *testing.T) {
+	as := appServiceWithNamespaces(t, `@_bridge_.*:test`, true)
+	stateKey := "@_bridge_bob:test"
+	event := mustBuildEvent(t, "@alice:test", "!room:test", "m.room.member", &stateKey)
+
+	if !(&OutputRoomEventConsumer{}).appServiceInterestedInEvent(context.Background(), as, event) {
+		t.Fatalf("expected appservice to be interested in an invite targeting its namespaced user")
+	}
+}
+
+func TestAppServiceInterestedInEvent_MembershipForUnrelatedUser(t *testing.T) {
+	as := appServiceWithNamespaces(t, `@_bridge_.*:test`, true)
+	stateKey := "@alice:test"
+	event := mustBuildEvent(t, "@bob:test", "!room:test", "m.room.member", &stateKey)
+
+	if (&OutputRoomEventConsumer{}).appServiceInterestedInEvent(context.Background(), as, event) {
+		t.Fatalf("did not expect appservice to be interested in a membership event for an unrelated user")
+	}
+}
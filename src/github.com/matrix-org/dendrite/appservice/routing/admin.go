@@ -0,0 +1,56 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/dendrite/appservice/types"
+	"github.com/matrix-org/util"
+)
+
+// applicationServiceHealth is the admin-facing view of a single application
+// service's transaction worker.
+type applicationServiceHealth struct {
+	ID                  string `json:"id"`
+	LastSuccessTime     int64  `json:"last_success_ts,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	BackingOff          bool   `json:"backing_off"`
+}
+
+// AdminWorkerStates implements:
+//
+//	GET /_dendrite/admin/appservices
+//
+// returning the health of every application service's transaction worker, so
+// operators can see at a glance which bridges are lagging or offline.
+func AdminWorkerStates(workerStates []types.ApplicationServiceWorkerState) http.Handler {
+	return util.MakeJSONAPI(util.NewJSONRequestHandler(func(req *http.Request) util.JSONResponse {
+		health := make([]applicationServiceHealth, 0, len(workerStates))
+		for _, ws := range workerStates {
+			lastSuccess, failures, backingOff := ws.Health.Snapshot()
+			entry := applicationServiceHealth{
+				ID:                  ws.AppService.ID,
+				ConsecutiveFailures: failures,
+				BackingOff:          backingOff,
+			}
+			if !lastSuccess.IsZero() {
+				entry.LastSuccessTime = lastSuccess.UnixNano() / int64(1000000)
+			}
+			health = append(health, entry)
+		}
+		return util.JSONResponse{Code: http.StatusOK, JSON: health}
+	}))
+}
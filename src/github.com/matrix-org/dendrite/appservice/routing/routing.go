@@ -0,0 +1,98 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package routing registers the HTTP handlers exposed by the appservice
+// component.
+package routing
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/appservice/types"
+	"github.com/matrix-org/dendrite/clientapi/auth/storage/accounts"
+	"github.com/matrix-org/dendrite/common"
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/matrix-org/dendrite/common/transactions"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+// Setup registers HTTP handlers with the given ServeMux.
+func Setup(
+	apiMux *mux.Router,
+	cfg config.Dendrite,
+	queryAPI roomserverAPI.RoomserverQueryAPI,
+	aliasAPI roomserverAPI.RoomserverAliasAPI,
+	accountDB *accounts.Database,
+	federation *gomatrixserverlib.FederationClient,
+	transactionsCache *transactions.Cache,
+	workerStates []types.ApplicationServiceWorkerState,
+) {
+	StartProtocolCache(cfg)
+
+	r0mux := apiMux.PathPrefix("/_matrix/client/r0").Subrouter()
+	adminMux := apiMux.PathPrefix("/_dendrite/admin").Subrouter()
+
+	adminMux.Handle("/appservices", AdminWorkerStates(workerStates)).Methods(http.MethodGet)
+
+	// Third-party network bridge lookup, used by clients wanting to know
+	// which external networks a registered application service can bridge to
+	// (https://matrix.org/docs/spec/application_service/r0.1.2#third-party-networks).
+	r0mux.Handle("/thirdparty/protocols", common.MakeExternalAPI(
+		"thirdparty_protocols",
+		func(req *http.Request) util.JSONResponse {
+			return Protocols(req, cfg, "")
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+
+	r0mux.Handle("/thirdparty/protocol/{protocolID}", common.MakeExternalAPI(
+		"thirdparty_protocol",
+		func(req *http.Request) util.JSONResponse {
+			vars := mux.Vars(req)
+			return Protocols(req, cfg, vars["protocolID"])
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+
+	r0mux.Handle("/thirdparty/user/{protocolID}", common.MakeExternalAPI(
+		"thirdparty_user_protocol",
+		func(req *http.Request) util.JSONResponse {
+			vars := mux.Vars(req)
+			return User(req, cfg, vars["protocolID"])
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+
+	r0mux.Handle("/thirdparty/user", common.MakeExternalAPI(
+		"thirdparty_user",
+		func(req *http.Request) util.JSONResponse {
+			return User(req, cfg, "")
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+
+	r0mux.Handle("/thirdparty/location/{protocolID}", common.MakeExternalAPI(
+		"thirdparty_location_protocol",
+		func(req *http.Request) util.JSONResponse {
+			vars := mux.Vars(req)
+			return Location(req, cfg, vars["protocolID"])
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+
+	r0mux.Handle("/thirdparty/location", common.MakeExternalAPI(
+		"thirdparty_location",
+		func(req *http.Request) util.JSONResponse {
+			return Location(req, cfg, "")
+		},
+	)).Methods(http.MethodGet, http.MethodOptions)
+}
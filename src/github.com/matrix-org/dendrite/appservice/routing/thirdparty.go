@@ -0,0 +1,208 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+)
+
+// protocolRefreshInterval is how often the cached /thirdparty/protocols
+// metadata is re-fetched from each application service in the background.
+const protocolRefreshInterval = time.Hour
+
+// asRequestTimeout bounds how long we wait for a reply from an application
+// service, so that one unreachable bridge can't hang a caller (or
+// StartProtocolCache's background refresh loop) indefinitely.
+const asRequestTimeout = 30 * time.Second
+
+// asHTTPClient is used for every outgoing request to an application
+// service's AS API.
+var asHTTPClient = &http.Client{Timeout: asRequestTimeout}
+
+// protocolCache holds the last known `/thirdparty/protocol/{protocol}`
+// response advertised by each application service, keyed by protocol name.
+// It is populated on startup and refreshed periodically so that a request
+// for `/thirdparty/protocols` doesn't need to fan out to every bridge.
+type protocolCache struct {
+	sync.RWMutex
+	protocols map[string]json.RawMessage
+}
+
+var cachedProtocols = protocolCache{protocols: map[string]json.RawMessage{}}
+
+// StartProtocolCache performs an initial fetch of the third-party protocol
+// metadata for every configured application service and then refreshes it on
+// a timer for as long as the process is running. Both the initial fetch and
+// the periodic refreshes run in the background so that a single
+// unreachable bridge can't hang component startup.
+func StartProtocolCache(cfg config.Dendrite) {
+	go func() {
+		refreshProtocols(cfg)
+		for range time.Tick(protocolRefreshInterval) {
+			refreshProtocols(cfg)
+		}
+	}()
+}
+
+func refreshProtocols(cfg config.Dendrite) {
+	for _, as := range cfg.Derived.ApplicationServices {
+		for _, protocol := range as.Protocols {
+			body, err := fetchFromApplicationService(as, "/thirdparty/protocol/"+url.PathEscape(protocol), nil)
+			if err != nil {
+				logrus.WithError(err).WithField("protocol", protocol).WithField("appservice", as.ID).
+					Warn("failed to refresh third-party protocol metadata")
+				continue
+			}
+			cachedProtocols.Lock()
+			cachedProtocols.protocols[protocol] = body
+			cachedProtocols.Unlock()
+		}
+	}
+}
+
+// Protocols implements:
+//
+//	GET /thirdparty/protocols
+//	GET /thirdparty/protocol/{protocolID}
+//
+// returning the cached metadata advertised by whichever appservice(s) bridge
+// that protocol.
+func Protocols(req *http.Request, cfg config.Dendrite, protocolID string) util.JSONResponse {
+	cachedProtocols.RLock()
+	defer cachedProtocols.RUnlock()
+
+	if protocolID != "" {
+		metadata, ok := cachedProtocols.protocols[protocolID]
+		if !ok {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: util.NotFound("unknown third-party protocol " + protocolID),
+			}
+		}
+		return util.JSONResponse{Code: http.StatusOK, JSON: metadata}
+	}
+
+	// Copy out from under the lock: the JSON encoder that serialises this
+	// response runs after Protocols returns, by which point refreshProtocols
+	// could otherwise be concurrently mutating the map it's reading.
+	protocols := make(map[string]json.RawMessage, len(cachedProtocols.protocols))
+	for protocol, metadata := range cachedProtocols.protocols {
+		protocols[protocol] = metadata
+	}
+
+	return util.JSONResponse{Code: http.StatusOK, JSON: protocols}
+}
+
+// User implements:
+//
+//	GET /thirdparty/user
+//	GET /thirdparty/user/{protocolID}
+//
+// by dispatching the lookup to whichever application service(s) bridge the
+// given protocol and relaying their response back to the caller.
+func User(req *http.Request, cfg config.Dendrite, protocolID string) util.JSONResponse {
+	return dispatchThirdpartyLookup(req, cfg, protocolID, "/thirdparty/user")
+}
+
+// Location implements:
+//
+//	GET /thirdparty/location
+//	GET /thirdparty/location/{protocolID}
+func Location(req *http.Request, cfg config.Dendrite, protocolID string) util.JSONResponse {
+	return dispatchThirdpartyLookup(req, cfg, protocolID, "/thirdparty/location")
+}
+
+// dispatchThirdpartyLookup finds the application service(s) that registered
+// support for protocolID (or, if protocolID is empty, every application
+// service that exposes third-party protocols at all), forwards the incoming
+// query string to each one's AS API and concatenates the results.
+func dispatchThirdpartyLookup(
+	req *http.Request, cfg config.Dendrite, protocolID, path string,
+) util.JSONResponse {
+	results := []json.RawMessage{}
+	for _, as := range cfg.Derived.ApplicationServices {
+		if !appServiceSupportsProtocol(as, protocolID) {
+			continue
+		}
+
+		requestPath := path
+		if protocolID != "" {
+			requestPath = path + "/" + url.PathEscape(protocolID)
+		}
+
+		body, err := fetchFromApplicationService(as, requestPath, req.URL.Query())
+		if err != nil {
+			logrus.WithError(err).WithField("appservice", as.ID).
+				Warn("third-party lookup request to appservice failed")
+			continue
+		}
+
+		var asResults []json.RawMessage
+		if err := json.Unmarshal(body, &asResults); err != nil {
+			logrus.WithError(err).WithField("appservice", as.ID).
+				Warn("appservice returned malformed third-party lookup response")
+			continue
+		}
+		results = append(results, asResults...)
+	}
+
+	return util.JSONResponse{Code: http.StatusOK, JSON: results}
+}
+
+func appServiceSupportsProtocol(as config.ApplicationService, protocolID string) bool {
+	if protocolID == "" {
+		return len(as.Protocols) > 0
+	}
+	for _, protocol := range as.Protocols {
+		if protocol == protocolID {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchFromApplicationService performs an authenticated GET request against
+// an application service's AS API and returns the raw response body.
+func fetchFromApplicationService(
+	as config.ApplicationService, path string, query url.Values,
+) (json.RawMessage, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("access_token", as.HSToken)
+
+	asURL := strings.TrimSuffix(as.URL, "/") + "/_matrix/app/v1" + path + "?" + query.Encode()
+
+	resp, err := asHTTPClient.Get(asURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/lib/pq"
+)
+
+const ephemeralSchema = `
+-- Ephemeral events (typing, receipts, presence) queued for delivery to an
+-- application service. Unlike PDUs these are not assigned their own
+-- transaction ID: they are batched into whichever PDU transaction is next
+-- delivered to the application service, or sent on their own if the PDU
+-- queue is empty.
+CREATE TABLE IF NOT EXISTS appservice_ephemeral_events (
+	id BIGSERIAL PRIMARY KEY,
+	as_id TEXT NOT NULL,
+	event_json TEXT NOT NULL,
+	inserted_ts BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS appservice_ephemeral_events_as_id_idx ON appservice_ephemeral_events (as_id);
+`
+
+const insertEphemeralEventSQL = "" +
+	"INSERT INTO appservice_ephemeral_events (as_id, event_json, inserted_ts) VALUES ($1, $2, $3)"
+
+const selectEphemeralEventsSQL = "" +
+	"SELECT id, event_json FROM appservice_ephemeral_events WHERE as_id = $1 ORDER BY id ASC"
+
+const deleteEphemeralEventsSQL = "" +
+	"DELETE FROM appservice_ephemeral_events WHERE as_id = $1 AND id = ANY($2)"
+
+type ephemeralStatements struct {
+	db                        *sql.DB
+	insertEphemeralEventStmt  *sql.Stmt
+	selectEphemeralEventsStmt *sql.Stmt
+	deleteEphemeralEventsStmt *sql.Stmt
+}
+
+func (s *ephemeralStatements) prepare(db *sql.DB) (err error) {
+	s.db = db
+	if _, err = db.Exec(ephemeralSchema); err != nil {
+		return err
+	}
+	if s.insertEphemeralEventStmt, err = db.Prepare(insertEphemeralEventSQL); err != nil {
+		return err
+	}
+	if s.selectEphemeralEventsStmt, err = db.Prepare(selectEphemeralEventsSQL); err != nil {
+		return err
+	}
+	if s.deleteEphemeralEventsStmt, err = db.Prepare(deleteEphemeralEventsSQL); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StoreEphemeralEvent queues a single ephemeral event (typing, receipt or
+// presence) for delivery to the given application service.
+func (d *Database) StoreEphemeralEvent(
+	ctx context.Context, asID string, eventJSON []byte, insertedTS int64,
+) error {
+	_, err := d.ephemeralStatements.insertEphemeralEventStmt.ExecContext(ctx, asID, eventJSON, insertedTS)
+	return err
+}
+
+// GetEphemeralEvents returns every ephemeral event currently queued for an
+// application service, along with the row IDs needed to remove them once
+// they've been delivered.
+func (d *Database) GetEphemeralEvents(
+	ctx context.Context, asID string,
+) (ids []int64, events []json.RawMessage, err error) {
+	rows, err := d.ephemeralStatements.selectEphemeralEventsStmt.QueryContext(ctx, asID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	for rows.Next() {
+		var id int64
+		var event json.RawMessage
+		if err = rows.Scan(&id, &event); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		events = append(events, event)
+	}
+	return ids, events, rows.Err()
+}
+
+// RemoveEphemeralEvents deletes the given ephemeral event rows once they've
+// been successfully delivered.
+func (d *Database) RemoveEphemeralEvents(ctx context.Context, asID string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := d.ephemeralStatements.deleteEphemeralEventsStmt.ExecContext(ctx, asID, pq.Array(ids))
+	return err
+}
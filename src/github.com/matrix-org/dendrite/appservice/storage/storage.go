@@ -0,0 +1,50 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage holds the persistence layer for the appservice component:
+// the queue of outbound transactions waiting to be delivered to each
+// configured application service.
+package storage
+
+import (
+	"database/sql"
+
+	// Side effect import for registering the postgres SQL driver
+	_ "github.com/lib/pq"
+)
+
+// Database is the interface used to persist and retrieve outbound
+// application service transactions and ephemeral events.
+type Database struct {
+	db                    *sql.DB
+	transactionStatements transactionStatements
+	ephemeralStatements   ephemeralStatements
+}
+
+// NewDatabase opens a connection to the appservice postgres database and
+// prepares the transaction queue schema and statements.
+func NewDatabase(dataSourceName string) (*Database, error) {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	d := &Database{db: db}
+	if err = d.transactionStatements.prepare(db); err != nil {
+		return nil, err
+	}
+	if err = d.ephemeralStatements.prepare(db); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+const transactionSchema = `
+-- Holds the outbound transaction queue for each application service. Rows
+-- are only removed once the application service has acknowledged the
+-- transaction with a 200 OK, so that a transaction surviving a crash or a
+-- prolonged outage can always be retried.
+CREATE TABLE IF NOT EXISTS appservice_transactions (
+	as_id TEXT NOT NULL,
+	txn_id BIGINT NOT NULL,
+	event_json TEXT NOT NULL,
+	inserted_ts BIGINT NOT NULL,
+
+	PRIMARY KEY (as_id, txn_id)
+);
+
+-- Tracks the last transaction ID handed out per application service, so
+-- StoreTransaction can allocate the next one under a row lock instead of
+-- computing MAX(txn_id)+1, which races when an appservice has more than
+-- one goroutine (e.g. the room consumer and the worker's empty-txn mint in
+-- nextTransaction) storing transactions for it concurrently.
+CREATE TABLE IF NOT EXISTS appservice_transaction_id (
+	as_id TEXT NOT NULL PRIMARY KEY,
+	txn_id BIGINT NOT NULL
+);
+`
+
+const selectNextTransactionIDSQL = "" +
+	"INSERT INTO appservice_transaction_id (as_id, txn_id) VALUES ($1, 1)" +
+	" ON CONFLICT (as_id) DO UPDATE SET txn_id = appservice_transaction_id.txn_id + 1" +
+	" RETURNING txn_id"
+
+const insertTransactionSQL = "" +
+	"INSERT INTO appservice_transactions (as_id, txn_id, event_json, inserted_ts)" +
+	" VALUES ($1, $2, $3, $4)"
+
+const selectOldestTransactionSQL = "" +
+	"SELECT txn_id, event_json FROM appservice_transactions" +
+	" WHERE as_id = $1 ORDER BY txn_id ASC LIMIT 1"
+
+const deleteTransactionSQL = "" +
+	"DELETE FROM appservice_transactions WHERE as_id = $1 AND txn_id = $2"
+
+type transactionStatements struct {
+	db                          *sql.DB
+	selectNextTransactionIDStmt *sql.Stmt
+	insertTransactionStmt       *sql.Stmt
+	selectOldestTransactionStmt *sql.Stmt
+	deleteTransactionStmt       *sql.Stmt
+}
+
+func (s *transactionStatements) prepare(db *sql.DB) (err error) {
+	s.db = db
+	if _, err = db.Exec(transactionSchema); err != nil {
+		return err
+	}
+	if s.selectNextTransactionIDStmt, err = db.Prepare(selectNextTransactionIDSQL); err != nil {
+		return err
+	}
+	if s.insertTransactionStmt, err = db.Prepare(insertTransactionSQL); err != nil {
+		return err
+	}
+	if s.selectOldestTransactionStmt, err = db.Prepare(selectOldestTransactionSQL); err != nil {
+		return err
+	}
+	if s.deleteTransactionStmt, err = db.Prepare(deleteTransactionSQL); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StoreTransaction persists a batch of events as a new transaction queued
+// for delivery to the given application service, assigning it the next
+// monotonically increasing transaction ID for that application service.
+// The ID is allocated and the transaction inserted inside a single DB
+// transaction, so that concurrent callers storing transactions for the same
+// application service serialize on the appservice_transaction_id row
+// instead of racing to compute the same next ID.
+func (d *Database) StoreTransaction(
+	ctx context.Context, asID string, eventJSON []byte, insertedTS int64,
+) (txnID int64, err error) {
+	dbTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			dbTx.Rollback() // nolint: errcheck
+		}
+	}()
+
+	if err = dbTx.StmtContext(ctx, d.transactionStatements.selectNextTransactionIDStmt).QueryRowContext(
+		ctx, asID,
+	).Scan(&txnID); err != nil {
+		return 0, err
+	}
+
+	if _, err = dbTx.StmtContext(ctx, d.transactionStatements.insertTransactionStmt).ExecContext(
+		ctx, asID, txnID, eventJSON, insertedTS,
+	); err != nil {
+		return 0, err
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return 0, err
+	}
+	return txnID, nil
+}
+
+// GetOldestTransaction returns the lowest-numbered queued transaction for an
+// application service, or ok == false if the queue is empty.
+func (d *Database) GetOldestTransaction(
+	ctx context.Context, asID string,
+) (txnID int64, eventJSON []byte, ok bool, err error) {
+	err = d.transactionStatements.selectOldestTransactionStmt.QueryRowContext(
+		ctx, asID,
+	).Scan(&txnID, &eventJSON)
+	if err == sql.ErrNoRows {
+		return 0, nil, false, nil
+	} else if err != nil {
+		return 0, nil, false, err
+	}
+	return txnID, eventJSON, true, nil
+}
+
+// RemoveTransaction deletes a transaction from the queue once the
+// application service has acknowledged it with a 200 OK.
+func (d *Database) RemoveTransaction(ctx context.Context, asID string, txnID int64) error {
+	_, err := d.transactionStatements.deleteTransactionStmt.ExecContext(ctx, asID, txnID)
+	return err
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package types holds the data structures shared between the appservice
+// component's consumers, workers and routing.
+package types
+
+import (
+	"sync"
+	"time"
+
+	"github.com/matrix-org/dendrite/common/config"
+)
+
+// ApplicationServiceWorkerState is a struct that relates an application
+// service, a sync.Cond that can be used to notify workers when there are new
+// events to be sent out, and a counter of how many events are waiting to be
+// sent, so that workers woken up spuriously can tell there is nothing to do.
+type ApplicationServiceWorkerState struct {
+	AppService  config.ApplicationService
+	Cond        *sync.Cond
+	EventsReady *int
+
+	// Health holds the worker's delivery health, which is updated by the
+	// transaction worker after every send attempt and read by the admin API.
+	// It has its own lock, kept separate from Cond's lock to avoid the admin API blocking the
+	// worker loop (or vice versa). It is a pointer, like Cond and
+	// EventsReady, so that copies of ApplicationServiceWorkerState (e.g. the
+	// one each worker goroutine receives) still share the same underlying
+	// lock and counters as the slice the admin API reads from.
+	Health *HealthState
+}
+
+// HealthState tracks whether an application service's transaction worker is
+// currently managing to deliver events to it.
+type HealthState struct {
+	mu sync.RWMutex
+
+	lastSuccessTime     time.Time
+	consecutiveFailures int
+	backingOff          bool
+}
+
+// RecordSuccess marks a transaction as having been accepted by the
+// application service.
+func (h *HealthState) RecordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastSuccessTime = time.Now()
+	h.consecutiveFailures = 0
+	h.backingOff = false
+}
+
+// RecordFailure marks a transaction attempt as having failed, whether due to
+// a network error or a non-2xx response, and returns the updated consecutive
+// failure count so the caller can compute the next backoff.
+func (h *HealthState) RecordFailure() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutiveFailures++
+	h.backingOff = true
+	return h.consecutiveFailures
+}
+
+// Snapshot returns a point-in-time copy of the health state, suitable for
+// serving over the admin API.
+func (h *HealthState) Snapshot() (lastSuccessTime time.Time, consecutiveFailures int, backingOff bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastSuccessTime, h.consecutiveFailures, h.backingOff
+}
+
+// NotifyNewEvents wakes up the worker associated with this application
+// service so that it can drain the queue of events waiting to be sent to it.
+func (a *ApplicationServiceWorkerState) NotifyNewEvents() {
+	a.Cond.L.Lock()
+	*a.EventsReady++
+	a.Cond.L.Unlock()
+	a.Cond.Broadcast()
+}
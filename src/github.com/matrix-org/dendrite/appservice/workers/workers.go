@@ -0,0 +1,220 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package workers contains the per-appservice goroutines that drain the
+// persisted transaction queue and deliver it to each application service.
+package workers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/appservice/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// initialBackoff is how long a worker waits before retrying the first
+	// failed delivery attempt for a transaction.
+	initialBackoff = 1 * time.Second
+	// maxBackoff caps the exponential backoff so a persistently offline
+	// bridge doesn't leave its worker sleeping for hours at a time.
+	maxBackoff = 5 * time.Minute
+	// sendTimeout bounds how long a worker waits for an application service
+	// to respond to a transaction, so a bridge that accepts the connection
+	// but never replies backs off and retries like any other failure
+	// instead of hanging the worker goroutine forever.
+	sendTimeout = 30 * time.Second
+)
+
+// sendHTTPClient is used for every outgoing transaction PUT to an
+// application service.
+var sendHTTPClient = &http.Client{Timeout: sendTimeout}
+
+// SetupTransactionWorkers starts one goroutine per configured application
+// service. Each goroutine blocks on its ApplicationServiceWorkerState's
+// sync.Cond until notified of new events, then drains the persisted
+// transaction queue for that application service, retrying failed
+// deliveries with a capped exponential backoff.
+func SetupTransactionWorkers(
+	appserviceDB *storage.Database,
+	workerStates []types.ApplicationServiceWorkerState,
+) error {
+	for _, ws := range workerStates {
+		go worker(appserviceDB, ws)
+	}
+	return nil
+}
+
+// worker is the per-appservice loop. It never returns.
+func worker(db *storage.Database, ws types.ApplicationServiceWorkerState) {
+	for {
+		ws.Cond.L.Lock()
+		for *ws.EventsReady == 0 {
+			ws.Cond.Wait()
+		}
+		*ws.EventsReady--
+		ws.Cond.L.Unlock()
+
+		drainQueue(db, ws)
+	}
+}
+
+// drainQueue repeatedly sends the oldest queued transaction for this
+// application service, along with any ephemeral events it has opted into
+// receiving, until the queue is empty. A transaction that cannot be
+// delivered is retried with a capped exponential backoff until the
+// application service accepts it; drainQueue also returns early if the queue
+// cannot be read or a delivered transaction cannot be removed from it.
+func drainQueue(db *storage.Database, ws types.ApplicationServiceWorkerState) {
+	backoff := initialBackoff
+	for {
+		txnID, pduJSON, ephemeral, ok, err := nextTransaction(db, ws)
+		if err != nil {
+			logrus.WithError(err).WithField("appservice", ws.AppService.ID).
+				Error("failed to read appservice transaction queue")
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if err = sendTransaction(ws.AppService.URL, ws.AppService.HSToken, txnID, pduJSON, ephemeral); err != nil {
+			failures := ws.Health.RecordFailure()
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"appservice": ws.AppService.ID,
+				"txn_id":     txnID,
+				"failures":   failures,
+			}).Warn("failed to deliver appservice transaction, backing off")
+
+			time.Sleep(backoff)
+			if backoff < maxBackoff {
+				backoff *= 2
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+			}
+			continue
+		}
+
+		ws.Health.RecordSuccess()
+		backoff = initialBackoff
+
+		if err = completeTransaction(db, ws, txnID, ephemeral); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"appservice": ws.AppService.ID,
+				"txn_id":     txnID,
+			}).Error("delivered appservice transaction but failed to remove it from the queue")
+			return
+		}
+	}
+}
+
+// nextTransaction assembles the next transaction to attempt for ws: the
+// oldest queued PDU batch, plus (if the application service opted into
+// de.sorunome.msc2409.push_ephemeral) any ephemeral events waiting for it.
+// If there are ephemeral events but no PDUs, an empty PDU transaction is
+// minted so the ephemeral events still have a txn ID to be delivered and
+// retried under.
+func nextTransaction(
+	db *storage.Database, ws types.ApplicationServiceWorkerState,
+) (txnID int64, pduJSON []byte, ephemeral []json.RawMessage, ok bool, err error) {
+	if ws.AppService.PushEphemeral {
+		if _, ephemeral, err = db.GetEphemeralEvents(context.Background(), ws.AppService.ID); err != nil {
+			return 0, nil, nil, false, err
+		}
+	}
+
+	txnID, pduJSON, havePDUs, err := db.GetOldestTransaction(context.Background(), ws.AppService.ID)
+	if err != nil {
+		return 0, nil, nil, false, err
+	}
+
+	if !havePDUs {
+		if len(ephemeral) == 0 {
+			return 0, nil, nil, false, nil
+		}
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		if txnID, err = db.StoreTransaction(context.Background(), ws.AppService.ID, []byte("[]"), now); err != nil {
+			return 0, nil, nil, false, err
+		}
+		pduJSON = []byte("[]")
+	}
+
+	return txnID, pduJSON, ephemeral, true, nil
+}
+
+// completeTransaction removes the delivered PDU transaction and any
+// ephemeral events that were batched into it from their respective queues.
+func completeTransaction(
+	db *storage.Database, ws types.ApplicationServiceWorkerState, txnID int64, ephemeral []json.RawMessage,
+) error {
+	if err := db.RemoveTransaction(context.Background(), ws.AppService.ID, txnID); err != nil {
+		return err
+	}
+	if len(ephemeral) == 0 {
+		return nil
+	}
+	ids, _, err := db.GetEphemeralEvents(context.Background(), ws.AppService.ID)
+	if err != nil {
+		return err
+	}
+	return db.RemoveEphemeralEvents(context.Background(), ws.AppService.ID, ids[:len(ephemeral)])
+}
+
+// transactionBody is the JSON body PUT to an application service, per the AS
+// API transactions endpoint, extended with the MSC2409 ephemeral events
+// array when the application service has opted in.
+type transactionBody struct {
+	Events    []json.RawMessage `json:"events"`
+	Ephemeral []json.RawMessage `json:"de.sorunome.msc2409.ephemeral,omitempty"`
+}
+
+// sendTransaction PUTs a single transaction to an application service and
+// returns an error unless the application service responds 200 OK.
+func sendTransaction(asURL, hsToken string, txnID int64, pduJSON []byte, ephemeral []json.RawMessage) error {
+	var events []json.RawMessage
+	if err := json.Unmarshal(pduJSON, &events); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(transactionBody{Events: events, Ephemeral: ephemeral})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/_matrix/app/v1/transactions/%d?access_token=%s", asURL, txnID, hsToken)
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sendHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("appservice responded with HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth implements the client-server API's /login handling for the
+// various login types dendrite supports.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	appserviceAuth "github.com/matrix-org/dendrite/appservice/auth"
+	"github.com/matrix-org/dendrite/clientapi/auth/storage/devices"
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/matrix-org/util"
+)
+
+// LoginTypeApplicationService is the value of the `type` field an
+// application service sends to /login to obtain a user-scoped access token
+// for any user in its namespace, as opposed to masquerading via its
+// `sender_localpart` device.
+const LoginTypeApplicationService = "m.login.application_service"
+
+// applicationServiceLoginRequest is the body of an
+// m.login.application_service request.
+type applicationServiceLoginRequest struct {
+	Type   string `json:"type"`
+	UserID string `json:"user,omitempty"`
+}
+
+// LoginApplicationService handles an m.login.application_service request: it
+// validates the caller's AS-Token, checks that the requested user_id (if
+// any) falls inside that application service's user namespace, and issues a
+// new device tagged as belonging to that application service.
+func LoginApplicationService(
+	req *http.Request, cfg config.Dendrite, deviceDB *devices.Database, accessToken string,
+) util.JSONResponse {
+	as, err := appserviceAuth.ValidateAccessToken(accessToken, cfg.Derived.ApplicationServices)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: util.Forbidden("invalid application service token"),
+		}
+	}
+
+	var r applicationServiceLoginRequest
+	if err = json.NewDecoder(req.Body).Decode(&r); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: util.BadJSON("invalid login request body"),
+		}
+	}
+
+	userID := r.UserID
+	if userID == "" {
+		userID = "@" + as.SenderLocalpart + ":" + cfg.Matrix.ServerName
+	} else if !as.IsInterestedInUserID(userID) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: util.Forbidden("application service namespace does not include " + userID),
+		}
+	}
+
+	deviceID, err := generateRandomToken()
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: util.Unknown("failed to generate device id: " + err.Error()),
+		}
+	}
+	deviceToken, err := generateRandomToken()
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: util.Unknown("failed to generate access token: " + err.Error()),
+		}
+	}
+
+	// Every device needs its own access token: reusing the AS's own
+	// as_token here would let any one of the AS's logged-in users
+	// authenticate as any other, since GetDeviceByAccessToken would resolve
+	// the shared token to whichever of their devices happened to be
+	// returned first.
+	device, err := deviceDB.CreateDeviceForAppService(req.Context(), userID, &deviceID, deviceToken, nil, as.ID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: util.Unknown("failed to create device: " + err.Error()),
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]string{
+			"user_id":      device.UserID,
+			"access_token": device.AccessToken,
+			"device_id":    device.ID,
+		},
+	}
+}
+
+// generateRandomToken returns a random, URL-safe string suitable for use as
+// either a device ID or an access token.
+func generateRandomToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devices
+
+import (
+	"context"
+	"database/sql"
+)
+
+const devicesSchema = `
+CREATE TABLE IF NOT EXISTS devices (
+	device_id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	access_token TEXT NOT NULL UNIQUE,
+	display_name TEXT,
+	appservice_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS devices_appservice_id_idx ON devices (appservice_id);
+`
+
+const insertDeviceSQL = "" +
+	"INSERT INTO devices (device_id, user_id, access_token, display_name, appservice_id)" +
+	" VALUES ($1, $2, $3, $4, $5)" +
+	" RETURNING device_id, user_id, access_token, display_name, appservice_id"
+
+const selectDeviceByTokenSQL = "" +
+	"SELECT device_id, user_id, access_token, display_name, appservice_id FROM devices WHERE access_token = $1"
+
+const deleteDevicesByAppServiceSQL = "" +
+	"DELETE FROM devices WHERE appservice_id = $1"
+
+type deviceStatements struct {
+	db                            *sql.DB
+	insertDeviceStmt              *sql.Stmt
+	selectDeviceByTokenStmt       *sql.Stmt
+	deleteDevicesByAppServiceStmt *sql.Stmt
+}
+
+func (s *deviceStatements) prepare(db *sql.DB) (err error) {
+	s.db = db
+	if _, err = db.Exec(devicesSchema); err != nil {
+		return err
+	}
+	if s.insertDeviceStmt, err = db.Prepare(insertDeviceSQL); err != nil {
+		return err
+	}
+	if s.selectDeviceByTokenStmt, err = db.Prepare(selectDeviceByTokenSQL); err != nil {
+		return err
+	}
+	if s.deleteDevicesByAppServiceStmt, err = db.Prepare(deleteDevicesByAppServiceSQL); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *deviceStatements) insertDevice(
+	ctx context.Context, deviceID, userID, accessToken, displayName, appServiceID string,
+) (*Device, error) {
+	d := Device{}
+	err := s.insertDeviceStmt.QueryRowContext(
+		ctx, deviceID, userID, accessToken, displayName, appServiceID,
+	).Scan(&d.ID, &d.UserID, &d.AccessToken, &d.DisplayName, &d.AppServiceID)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *deviceStatements) selectDeviceByToken(ctx context.Context, token string) (*Device, error) {
+	d := Device{}
+	err := s.selectDeviceByTokenStmt.QueryRowContext(ctx, token).Scan(
+		&d.ID, &d.UserID, &d.AccessToken, &d.DisplayName, &d.AppServiceID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *deviceStatements) deleteDevicesByAppService(ctx context.Context, appServiceID string) error {
+	_, err := s.deleteDevicesByAppServiceStmt.ExecContext(ctx, appServiceID)
+	return err
+}
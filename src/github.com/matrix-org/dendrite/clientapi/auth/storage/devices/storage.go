@@ -0,0 +1,95 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package devices holds the persistence layer for client devices and their
+// access tokens.
+package devices
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// Device represents a single login session for a user, identified by an
+// access token.
+type Device struct {
+	ID          string
+	UserID      string
+	AccessToken string
+	DisplayName string
+
+	// AppServiceID is set when this device was created on behalf of an
+	// application service (e.g. via m.login.application_service) rather than
+	// directly by the user, so that it can be found and revoked in bulk if
+	// the application service's registration is later removed.
+	AppServiceID string
+}
+
+// Database represents a device database.
+type Database struct {
+	db               *sql.DB
+	deviceStatements deviceStatements
+}
+
+// NewDatabase creates a new device database
+func NewDatabase(dataSourceName string) (*Database, error) {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	d := &Database{db: db}
+	if err = d.deviceStatements.prepare(db); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// CreateDevice creates a new device for a user, optionally with a caller
+// supplied device ID and display name. The device is not tagged as
+// belonging to any application service; see CreateDeviceForAppService.
+func (d *Database) CreateDevice(
+	ctx context.Context, userID string, deviceID *string, accessToken string, displayName *string,
+) (*Device, error) {
+	return d.CreateDeviceForAppService(ctx, userID, deviceID, accessToken, displayName, "")
+}
+
+// CreateDeviceForAppService is like CreateDevice but additionally tags the
+// resulting device with the ID of the application service that created it.
+func (d *Database) CreateDeviceForAppService(
+	ctx context.Context, userID string, deviceID *string, accessToken string, displayName *string, appServiceID string,
+) (*Device, error) {
+	id := ""
+	if deviceID != nil {
+		id = *deviceID
+	}
+	name := ""
+	if displayName != nil {
+		name = *displayName
+	}
+	return d.deviceStatements.insertDevice(ctx, id, userID, accessToken, name, appServiceID)
+}
+
+// GetDeviceByAccessToken looks up the device (if any) associated with an
+// access token.
+func (d *Database) GetDeviceByAccessToken(ctx context.Context, token string) (*Device, error) {
+	return d.deviceStatements.selectDeviceByToken(ctx, token)
+}
+
+// RemoveDevicesByAppService deletes every device created on behalf of the
+// given application service, for use when its registration is removed.
+func (d *Database) RemoveDevicesByAppService(ctx context.Context, appServiceID string) error {
+	return d.deviceStatements.deleteDevicesByAppService(ctx, appServiceID)
+}
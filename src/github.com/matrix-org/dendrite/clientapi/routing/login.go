@@ -0,0 +1,77 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/auth/storage/devices"
+	"github.com/matrix-org/dendrite/common"
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/matrix-org/util"
+)
+
+// loginTypeRequest is decoded first so Login can tell which login type the
+// caller is asking for before handing the (still-intact) body off to the
+// type-specific handler.
+type loginTypeRequest struct {
+	Type string `json:"type"`
+}
+
+// Login handles POST /login, dispatching to the handler for the requested
+// login type. Only m.login.application_service is currently implemented;
+// every other type, including the usual m.login.password, is rejected since
+// this component doesn't implement them yet.
+func Login(req *http.Request, cfg config.Dendrite, deviceDB *devices.Database) util.JSONResponse {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: util.BadJSON("invalid login request body"),
+		}
+	}
+
+	var r loginTypeRequest
+	if err = json.Unmarshal(body, &r); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: util.BadJSON("invalid login request body"),
+		}
+	}
+
+	// Hand the type-specific handler an intact body to decode again.
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	switch r.Type {
+	case auth.LoginTypeApplicationService:
+		accessToken, err := common.ExtractAccessToken(req)
+		if err != nil {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: util.Forbidden("missing access token"),
+			}
+		}
+		return auth.LoginApplicationService(req, cfg, deviceDB, accessToken)
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: util.BadJSON("unsupported login type " + r.Type),
+		}
+	}
+}
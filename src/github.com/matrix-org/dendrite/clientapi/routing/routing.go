@@ -0,0 +1,43 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package routing registers the HTTP handlers exposed by the client-server
+// API component.
+package routing
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/clientapi/auth/storage/devices"
+	"github.com/matrix-org/dendrite/common"
+	"github.com/matrix-org/dendrite/common/config"
+	"github.com/matrix-org/util"
+)
+
+// Setup registers HTTP handlers with the given ServeMux.
+func Setup(
+	apiMux *mux.Router,
+	cfg config.Dendrite,
+	deviceDB *devices.Database,
+) {
+	r0mux := apiMux.PathPrefix("/_matrix/client/r0").Subrouter()
+
+	r0mux.Handle("/login", common.MakeExternalAPI(
+		"login",
+		func(req *http.Request) util.JSONResponse {
+			return Login(req, cfg, deviceDB)
+		},
+	)).Methods(http.MethodPost, http.MethodOptions)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "regexp"
+
+// ApplicationService represents a Matrix application service as loaded from
+// its registration file.
+type ApplicationService struct {
+	ID              string                                   `yaml:"id"`
+	URL             string                                   `yaml:"url"`
+	ASToken         string                                   `yaml:"as_token"`
+	HSToken         string                                   `yaml:"hs_token"`
+	SenderLocalpart string                                   `yaml:"sender_localpart"`
+	RateLimited     bool                                     `yaml:"rate_limited"`
+	Protocols       []string                                 `yaml:"protocols"`
+	NamespaceMap    map[string][]ApplicationServiceNamespace `yaml:"namespaces"`
+
+	// PushEphemeral enables the de.sorunome.msc2409.push_ephemeral
+	// registration flag, under which typing, receipt and presence events
+	// that match this application service's namespaces are delivered
+	// alongside PDUs in its outbound transactions.
+	PushEphemeral bool `yaml:"de.sorunome.msc2409.push_ephemeral"`
+}
+
+// ApplicationServiceNamespace is a namespace defined by an application
+// service's registration, as documented at
+// https://matrix.org/docs/spec/application_service/r0.1.2#registration
+type ApplicationServiceNamespace struct {
+	Exclusive bool   `yaml:"exclusive"`
+	Regex     string `yaml:"regex"`
+	GroupID   string `yaml:"group_id,omitempty"`
+
+	// RegexpObject is the compiled form of Regex. It is populated once, by
+	// ApplicationService.CompileNamespaces, so that the hot paths that match events against
+	// namespaces never need to recompile a regexp.
+	RegexpObject *regexp.Regexp `yaml:"-"`
+}
+
+// CompileNamespaces compiles the regexes of all of this application
+// service's namespaces, populating RegexpObject on each one so that event
+// matching never needs to recompile a pattern on the hot path.
+func (a *ApplicationService) CompileNamespaces() error {
+	for kind, namespaces := range a.NamespaceMap {
+		for i := range namespaces {
+			re, err := regexp.Compile(namespaces[i].Regex)
+			if err != nil {
+				return err
+			}
+			namespaces[i].RegexpObject = re
+		}
+		a.NamespaceMap[kind] = namespaces
+	}
+	return nil
+}
+
+// UserNamespaces returns the "users" namespaces for this application service.
+func (a *ApplicationService) UserNamespaces() []ApplicationServiceNamespace {
+	return a.NamespaceMap["users"]
+}
+
+// AliasNamespaces returns the "aliases" namespaces for this application
+// service.
+func (a *ApplicationService) AliasNamespaces() []ApplicationServiceNamespace {
+	return a.NamespaceMap["aliases"]
+}
+
+// RoomNamespaces returns the "rooms" namespaces for this application service.
+func (a *ApplicationService) RoomNamespaces() []ApplicationServiceNamespace {
+	return a.NamespaceMap["rooms"]
+}
+
+// IsInterestedInUserID returns true if the application service's "users"
+// namespaces match the given user ID.
+func (a *ApplicationService) IsInterestedInUserID(userID string) bool {
+	return namespacesMatch(a.UserNamespaces(), userID)
+}
+
+// IsInterestedInRoomAlias returns true if the application service's
+// "aliases" namespaces match the given room alias.
+func (a *ApplicationService) IsInterestedInRoomAlias(roomAlias string) bool {
+	return namespacesMatch(a.AliasNamespaces(), roomAlias)
+}
+
+// IsInterestedInRoomID returns true if the application service's "rooms"
+// namespaces match the given room ID.
+func (a *ApplicationService) IsInterestedInRoomID(roomID string) bool {
+	return namespacesMatch(a.RoomNamespaces(), roomID)
+}
+
+func namespacesMatch(namespaces []ApplicationServiceNamespace, s string) bool {
+	for _, ns := range namespaces {
+		if ns.RegexpObject != nil && ns.RegexpObject.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
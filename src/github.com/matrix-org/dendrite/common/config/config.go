@@ -0,0 +1,60 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// DataSource is a database connection string, as read from the
+// configuration file's `database` section.
+type DataSource string
+
+// Dendrite is the root of the dendrite configuration tree.
+type Dendrite struct {
+	Matrix   Matrix
+	Database Database
+	Derived  Derived
+	Kafka    Kafka
+}
+
+// Matrix holds the server-wide configuration that isn't specific to any one
+// component.
+type Matrix struct {
+	// ServerName is the domain name of this homeserver, as it appears in
+	// user, room and event IDs.
+	ServerName string `yaml:"server_name"`
+}
+
+// Kafka holds the names of the kafka topics used to stream events between
+// components.
+type Kafka struct {
+	Topics struct {
+		OutputRoomEvent     string `yaml:"output_room_event"`
+		OutputTypingEvent   string `yaml:"output_typing_event"`
+		OutputReceiptEvent  string `yaml:"output_receipt_event"`
+		OutputPresenceEvent string `yaml:"output_presence_event"`
+	} `yaml:"topics"`
+}
+
+// Database holds the connection strings for each component's database.
+type Database struct {
+	AppService DataSource `yaml:"appservice"`
+}
+
+// Derived holds values that are computed from the rest of the
+// configuration at load time, rather than read directly from the
+// configuration file.
+type Derived struct {
+	// ApplicationServices are the parsed and validated application service
+	// registration files referenced by the top level configuration.
+	ApplicationServices []ApplicationService
+}
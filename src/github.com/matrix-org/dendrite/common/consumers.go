@@ -0,0 +1,74 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// PartitionOffsetStore is something that remembers the offset that a
+// consumer got up to for a given topic and partition.
+type PartitionOffsetStore interface {
+	SetPartitionOffset(topic string, partition int32, offset int64) error
+	PartitionOffset(topic string, partition int32) (int64, error)
+}
+
+// ContinualConsumer consumes a kafka topic from the last offset it
+// remembers, forever, calling ProcessMessage for each message it receives.
+type ContinualConsumer struct {
+	Topic          string
+	Consumer       sarama.Consumer
+	PartitionStore PartitionOffsetStore
+	ProcessMessage func(message *sarama.ConsumerMessage) error
+}
+
+// Start starts the consumer consuming every partition of its topic from the
+// last remembered offset (or the oldest available message, if none is
+// remembered).
+func (c *ContinualConsumer) Start() error {
+	partitions, err := c.Consumer.Partitions(c.Topic)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		offset := sarama.OffsetOldest
+		if c.PartitionStore != nil {
+			if stored, serr := c.PartitionStore.PartitionOffset(c.Topic, partition); serr == nil {
+				offset = stored + 1
+			}
+		}
+
+		pc, err := c.Consumer.ConsumePartition(c.Topic, partition, offset)
+		if err != nil {
+			return err
+		}
+
+		go c.consumePartition(pc, partition)
+	}
+
+	return nil
+}
+
+func (c *ContinualConsumer) consumePartition(pc sarama.PartitionConsumer, partition int32) {
+	for msg := range pc.Messages() {
+		if err := c.ProcessMessage(msg); err != nil {
+			continue
+		}
+		if c.PartitionStore != nil {
+			c.PartitionStore.SetPartitionOffset(c.Topic, partition, msg.Offset) // nolint: errcheck
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/matrix-org/util"
+)
+
+// MakeExternalAPI turns a util.JSONResponse-returning handler function into
+// an http.Handler. The name labels the endpoint at the call site; it is not
+// used by the returned handler.
+func MakeExternalAPI(name string, f func(*http.Request) util.JSONResponse) http.Handler {
+	return util.MakeJSONAPI(util.NewJSONRequestHandler(f))
+}
+
+// ErrMissingToken is returned by ExtractAccessToken when the request carries
+// neither an Authorization header nor an access_token query parameter.
+var ErrMissingToken = errors.New("missing access token")
+
+// ExtractAccessToken extracts the caller's access token from a request,
+// accepting either the "Authorization: Bearer <token>" header or the
+// "access_token" query parameter, per the client-server API's two
+// authentication conventions.
+func ExtractAccessToken(req *http.Request) (string, error) {
+	if authHeader := req.Header.Get("Authorization"); authHeader != "" {
+		return strings.TrimPrefix(authHeader, "Bearer "), nil
+	}
+	if token := req.URL.Query().Get("access_token"); token != "" {
+		return token, nil
+	}
+	return "", ErrMissingToken
+}